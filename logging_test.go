@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestNewLoggerRejectsUnknownFormatOrLevel(t *testing.T) {
+	if _, err := newLogger("xml", "info"); err == nil {
+		t.Errorf("Expected an error for an unknown log format")
+	}
+	if _, err := newLogger("json", "verbose"); err == nil {
+		t.Errorf("Expected an error for an unknown log level")
+	}
+	if _, err := newLogger("logfmt", "debug"); err != nil {
+		t.Errorf("Expected no error for valid flags, got %v", err)
+	}
+}
+
+func TestLoggerFromContextFallsBackToDefault(t *testing.T) {
+	if got := loggerFromContext(context.Background()); got != slog.Default() {
+		t.Errorf("Expected slog.Default() when no logger is attached")
+	}
+
+	logger, err := newLogger("json", "debug")
+	if err != nil {
+		t.Fatalf("newLogger returned an error: %v", err)
+	}
+	ctx := contextWithLogger(context.Background(), logger)
+	if got := loggerFromContext(ctx); got != logger {
+		t.Errorf("Expected the attached logger to be returned")
+	}
+}
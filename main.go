@@ -1,19 +1,20 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -22,93 +23,248 @@ import (
 )
 
 type Config struct {
-	Repos          []string `json:"repos"`
-	IP             string   `json:"ip"`
-	Port           int      `json:"port"`
-	Endpoint       string   `json:"endpoint"`
-	TickerInterval int      `json:"ticker_interval"`
+	Repos                  []RepoSpec `json:"repos"`
+	ReposRoot              string     `json:"repos_root"`
+	IP                     string     `json:"ip"`
+	Port                   int        `json:"port"`
+	Endpoint               string     `json:"endpoint"`
+	ProbeEndpoint          string     `json:"probe_endpoint"`
+	ProbeAllowedRepos      []string   `json:"probe_allowed_repos"`
+	TickerInterval         int        `json:"ticker_interval"`
+	ShutdownTimeoutSeconds int        `json:"shutdown_timeout_seconds"`
 }
 
 const (
-	defaultIP             = "0.0.0.0"
-	defaultPort           = 8080
-	defaultEndpoint       = "/metrics"
-	defaultTickerInterval = 60 // in seconds
+	defaultIP                     = "0.0.0.0"
+	defaultPort                   = 8080
+	defaultEndpoint               = "/metrics"
+	defaultProbeEndpoint          = "/probe"
+	defaultTickerInterval         = 60 // in seconds
+	defaultShutdownTimeoutSeconds = 10
 )
 
-var (
-	lastTransactionTimestamp = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "borgbackup_last_transaction_timestamp",
-			Help: "Unix timestamp of the last transaction in the BorgBackup repository",
-		},
-		[]string{"repo"},
-	)
-	lastTransactionNumber = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "borgbackup_last_transaction_number",
-			Help: "Number of the last transaction in the BorgBackup repository",
-		},
-		[]string{"repo"},
-	)
-)
-
-func init() {
-	prometheus.MustRegister(lastTransactionTimestamp)
-	prometheus.MustRegister(lastTransactionNumber)
-}
-
 func main() {
 	configPath := flag.String("config", "config.json", "Path to the configuration file")
+	logFormat := flag.String("log-format", "logfmt", "Log output format: logfmt or json")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn, or error")
+	webConfigFile := flag.String("web.config.file", "", "Path to a web config file enabling TLS and/or basic auth")
 	flag.Parse()
 
+	logger, err := newLogger(*logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging flags: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
 	config, err := loadConfig(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		logger.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
 	}
 
 	applyDefaults(config)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	var configPtr atomic.Pointer[Config]
+	configPtr.Store(config)
+
+	sources, err := newRepoSources(config.Repos, config.ReposRoot)
+	if err != nil {
+		logger.Error("Failed to configure repo sources", "error", err)
+		os.Exit(1)
+	}
+
+	webConfig, err := loadWebConfig(*webConfigFile)
+	if err != nil {
+		logger.Error("Failed to load web config file", "error", err)
+		os.Exit(1)
+	}
+
+	ctx := contextWithLogger(context.Background(), logger)
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	supervisor := newWorkerSupervisor(logger)
+	supervisor.reconcile(ctx, sources, time.Duration(config.TickerInterval)*time.Second)
+
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	var wg sync.WaitGroup
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		updateMetricsLoop(ctx, config.Repos, time.Duration(config.TickerInterval)*time.Second)
-	}()
+	mux := http.NewServeMux()
+	mux.Handle(config.Endpoint, promhttp.Handler())
+	mux.HandleFunc(config.ProbeEndpoint, probeHandler(&configPtr))
+
+	var handler http.Handler = mux
+	if webConfig != nil {
+		handler = basicAuthMiddleware(webConfig.BasicAuthUsers, handler)
+	}
 
 	serverAddr := fmt.Sprintf("%s:%d", config.IP, config.Port)
-	http.Handle(config.Endpoint, promhttp.Handler())
-	server := &http.Server{Addr: serverAddr}
+	server := &http.Server{
+		Addr:        serverAddr,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	if webConfig != nil && webConfig.TLSServerConfig != nil {
+		tlsConfig, err := buildTLSConfig(webConfig.TLSServerConfig)
+		if err != nil {
+			logger.Error("Failed to configure TLS", "error", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = tlsConfig
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Printf("Starting Prometheus exporter on %s%s\n", serverAddr, config.Endpoint)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server error: %v", err)
+		logger.Info("Starting Prometheus exporter", "addr", serverAddr, "metrics_endpoint", config.Endpoint, "probe_endpoint", config.ProbeEndpoint, "tls", server.TLSConfig != nil)
+
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server error", "error", err)
 		}
 	}()
 
-	<-sigChan
-	log.Println("Received termination signal. Shutting down...")
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			reloadConfig(ctx, logger, *configPath, &configPtr, supervisor)
+			continue
+		}
+		break
+	}
+	logger.Info("Received termination signal, shutting down")
 
 	cancel()
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownTimeout := time.Duration(configPtr.Load().ShutdownTimeoutSeconds) * time.Second
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutdownCancel()
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Error shutting down server: %v", err)
+		logger.Error("Error shutting down server", "error", err)
+	}
+
+	if !supervisor.wait(shutdownTimeout) {
+		logger.Error("Timed out waiting for repo workers to stop", "timeout", shutdownTimeout)
 	}
 
 	wg.Wait()
-	log.Println("Exporter stopped.")
+	logger.Info("Exporter stopped")
+}
+
+// reloadConfig re-reads configPath and reconciles the running repo workers
+// against it: repos removed from the file stop, repos added to it start,
+// and repos that remain pick up the new scrape interval without losing
+// their metric state. configPtr is swapped to the reloaded config with a
+// single atomic Store, so probeHandler (running on its own goroutine per
+// request) always sees either the old or the new config in full, never a
+// torn mix of the two. The HTTP listener and its address/endpoints are left
+// untouched, since the request is to reload repos, not rebind the server.
+func reloadConfig(ctx context.Context, logger *slog.Logger, configPath string, configPtr *atomic.Pointer[Config], supervisor *workerSupervisor) {
+	newConfig, err := loadConfig(configPath)
+	if err != nil {
+		logger.Error("Failed to reload configuration, keeping previous state", "error", err)
+		return
+	}
+	applyDefaults(newConfig)
+
+	newSources, err := newRepoSources(newConfig.Repos, newConfig.ReposRoot)
+	if err != nil {
+		logger.Error("Failed to configure repo sources from reloaded configuration, keeping previous state", "error", err)
+		return
+	}
+
+	interval := time.Duration(newConfig.TickerInterval) * time.Second
+	supervisor.reconcile(ctx, newSources, interval)
+	configPtr.Store(newConfig)
+	logger.Info("Reloaded configuration", "repos", len(newSources), "ticker_interval", interval)
+}
+
+// probeHandler implements a Prometheus blackbox-exporter-style multi-target
+// probe: the caller names a single repo via ?target=, the handler scrapes
+// it on the spot against a fresh registry, and returns the resulting
+// metrics plus a borgbackup_probe_success gauge. This lets one exporter
+// cover many repos via Prometheus relabeling instead of enumerating every
+// repo in config.json. configPtr is loaded once per request so a concurrent
+// SIGHUP reload can't hand the handler a torn mix of old and new config.
+func probeHandler(configPtr *atomic.Pointer[Config]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := configPtr.Load()
+
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		if !isProbeTargetAllowed(config, target) {
+			http.Error(w, fmt.Sprintf("target %q is not allowed by probe_allowed_repos", target), http.StatusForbidden)
+			return
+		}
+
+		source, err := newRepoSource(RepoSpec{URL: target}, config.ReposRoot)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid target %q: %v", target, err), http.StatusBadRequest)
+			return
+		}
+
+		reg := prometheus.NewRegistry()
+		metrics := newRepoMetrics()
+		metrics.MustRegister(reg)
+
+		probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "borgbackup_probe_success",
+			Help: "Displays whether or not the probe of the target repo was a success",
+		})
+		reg.MustRegister(probeSuccess)
+
+		if err := updateRepoMetrics(r.Context(), source, metrics); err != nil {
+			loggerFromContext(r.Context()).Error("Probe failed", "repo", target, "error", err)
+			probeSuccess.Set(0)
+		} else {
+			probeSuccess.Set(1)
+		}
+
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// isProbeTargetAllowed reports whether target may be probed. A non-empty
+// probe_allowed_repos restricts /probe to exactly those repos, whatever
+// scheme they use. With the default empty list, /probe only accepts local
+// targets (a bare path or file://), and only once repos_root is configured:
+// remote schemes like ssh:// and s3:// would otherwise let an unauthenticated
+// caller make the exporter dial an arbitrary host or use its ambient cloud
+// credentials against a bucket of the caller's choosing, and an unconfined
+// repos_root would let that same caller use resolveLocalRepoPath's no-op
+// containment to probe arbitrary local paths on the host. Either case always
+// requires an explicit allow-list entry instead.
+func isProbeTargetAllowed(config *Config, target string) bool {
+	if len(config.ProbeAllowedRepos) > 0 {
+		for _, allowed := range config.ProbeAllowedRepos {
+			if allowed == target {
+				return true
+			}
+		}
+		return false
+	}
+
+	if config.ReposRoot == "" {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || u.Scheme == "file"
 }
 
 func loadConfig(filename string) (*Config, error) {
@@ -137,64 +293,15 @@ func applyDefaults(config *Config) {
 	if config.Endpoint == "" {
 		config.Endpoint = defaultEndpoint
 	}
+	if config.ProbeEndpoint == "" {
+		config.ProbeEndpoint = defaultProbeEndpoint
+	}
 	if config.TickerInterval == 0 {
 		config.TickerInterval = defaultTickerInterval
 	}
-}
-
-func updateMetricsLoop(ctx context.Context, repos []string, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-  // Perform the first update immediately
-	for _, repo := range repos {
-		updateRepoMetrics(repo)
+	if config.ShutdownTimeoutSeconds == 0 {
+		config.ShutdownTimeoutSeconds = defaultShutdownTimeoutSeconds
 	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Stopping metrics update loop.")
-			return
-		case <-ticker.C:
-			for _, repo := range repos {
-				updateRepoMetrics(repo)
-			}
-		}
-	}
-}
-
-func updateRepoMetrics(repo string) {
-	transactionsFile := filepath.Join(repo, "transactions")
-	file, err := os.Open(transactionsFile)
-	if err != nil {
-		log.Printf("Failed to open transactions file for repo %s: %v", repo, err)
-		return
-	}
-	defer file.Close()
-
-	var lastLine string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lastLine = scanner.Text()
-	}
-
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading transactions file for repo %s: %v", repo, err)
-		return
-	}
-
-  // instead of deferring as usual, close as soon as the 
-  file.Close()
-
-	transactionNumber, timestamp, err := parseTransactionLine(lastLine)
-	if err != nil {
-		log.Printf("Failed to parse transactions file for repo %s: %v", repo, err)
-		return
-	}
-
-	lastTransactionTimestamp.WithLabelValues(repo).Set(float64(timestamp))
-	lastTransactionNumber.WithLabelValues(repo).Set(float64(transactionNumber))
 }
 
 func parseTransactionLine(line string) (int, int64, error) {
@@ -1,26 +1,101 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestParseTransactionLine(t *testing.T) {
 	line := "transaction 6374, UTC time 2024-11-30T11:45:36.870201"
-	// number, timestamp, err := parseTransactionLine(line)
-	number, err := parseTransactionLine(line)
+	number, timestamp, err := parseTransactionLine(line)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 
 	expectedNumber := 6374
-	// expectedTime := time.Date(2024, 11, 30, 11, 45, 36, 870201000, time.UTC).Unix()
+	expectedTime := time.Date(2024, 11, 30, 11, 45, 36, 870201000, time.UTC).Unix()
 
 	if number != expectedNumber {
 		t.Errorf("Expected transaction number %d, got %d", expectedNumber, number)
 	}
 
-	/* if timestamp != expectedTime {
+	if timestamp != expectedTime {
 		t.Errorf("Expected timestamp %d, got %d", expectedTime, timestamp)
-	} */
+	}
+}
+
+func TestIsProbeTargetAllowed(t *testing.T) {
+	noReposRootConfig := &Config{}
+	if isProbeTargetAllowed(noReposRootConfig, "/any/repo") {
+		t.Errorf("Expected local targets to be rejected when probe_allowed_repos is empty and repos_root is unset")
+	}
+
+	openConfig := &Config{ReposRoot: "/srv/backups"}
+	if !isProbeTargetAllowed(openConfig, "/any/repo") {
+		t.Errorf("Expected any local target to be allowed when probe_allowed_repos is empty and repos_root is set")
+	}
+	if !isProbeTargetAllowed(openConfig, "file:///any/repo") {
+		t.Errorf("Expected a file:// target to be allowed when probe_allowed_repos is empty and repos_root is set")
+	}
+	if isProbeTargetAllowed(openConfig, "s3://any-bucket/prefix") {
+		t.Errorf("Expected an s3:// target to be rejected when probe_allowed_repos is empty")
+	}
+	if isProbeTargetAllowed(openConfig, "ssh://attacker.example/repo") {
+		t.Errorf("Expected an ssh:// target to be rejected when probe_allowed_repos is empty")
+	}
+
+	restrictedConfig := &Config{ProbeAllowedRepos: []string{"/srv/backups/repo1", "s3://backups/repo2"}}
+	if !isProbeTargetAllowed(restrictedConfig, "/srv/backups/repo1") {
+		t.Errorf("Expected allow-listed target to be allowed")
+	}
+	if !isProbeTargetAllowed(restrictedConfig, "s3://backups/repo2") {
+		t.Errorf("Expected allow-listed s3:// target to be allowed")
+	}
+	if isProbeTargetAllowed(restrictedConfig, "/srv/backups/repo3") {
+		t.Errorf("Expected target outside the allow-list to be rejected")
+	}
 }
 
+func TestReloadConfigSwapsConfigAtomically(t *testing.T) {
+	logger, err := newLogger("logfmt", "error")
+	if err != nil {
+		t.Fatalf("newLogger returned an error: %v", err)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(reposRoot string) {
+		data, err := json.Marshal(Config{ReposRoot: reposRoot})
+		if err != nil {
+			t.Fatalf("marshaling config: %v", err)
+		}
+		if err := os.WriteFile(configPath, data, 0o644); err != nil {
+			t.Fatalf("writing config: %v", err)
+		}
+	}
+
+	writeConfig("/srv/backups/old")
+	initial, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("loadConfig returned an error: %v", err)
+	}
+	applyDefaults(initial)
+
+	var configPtr atomic.Pointer[Config]
+	configPtr.Store(initial)
+
+	supervisor := newWorkerSupervisor(logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	writeConfig("/srv/backups/new")
+	reloadConfig(ctx, logger, configPath, &configPtr, supervisor)
+
+	if got := configPtr.Load().ReposRoot; got != "/srv/backups/new" {
+		t.Errorf("Expected reload to swap in the new repos_root, got %q", got)
+	}
+}
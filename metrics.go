@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// repoMetrics bundles the gauges, counters, and histograms collected for a
+// single repo scrape. The same collection code targets either the global
+// registry (the periodic background loop) or a throwaway per-request
+// registry (the /probe handler), so concurrent probes never clobber each
+// other's series or the long-lived /metrics output.
+type repoMetrics struct {
+	lastTransactionTimestamp *prometheus.GaugeVec
+	lastTransactionNumber    *prometheus.GaugeVec
+	repoSizeBytes            *prometheus.GaugeVec
+	repoSegmentCount         *prometheus.GaugeVec
+	repoSegmentMaxID         *prometheus.GaugeVec
+	repoConfigMtimeSeconds   *prometheus.GaugeVec
+	repoSpecialFilePresent   *prometheus.GaugeVec
+	scrapeErrorsTotal        *prometheus.CounterVec
+	scrapeDurationSeconds    *prometheus.HistogramVec
+}
+
+func newRepoMetrics() *repoMetrics {
+	return &repoMetrics{
+		lastTransactionTimestamp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "borgbackup_last_transaction_timestamp",
+				Help: "Unix timestamp of the last transaction in the BorgBackup repository",
+			},
+			[]string{"repo"},
+		),
+		lastTransactionNumber: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "borgbackup_last_transaction_number",
+				Help: "Number of the last transaction in the BorgBackup repository",
+			},
+			[]string{"repo"},
+		),
+		repoSizeBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "borgbackup_repo_size_bytes",
+				Help: "Total on-disk size of the repository's data/ segment files, in bytes",
+			},
+			[]string{"repo"},
+		),
+		repoSegmentCount: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "borgbackup_repo_segment_count",
+				Help: "Number of segment files in the repository's data/ directory",
+			},
+			[]string{"repo"},
+		),
+		repoSegmentMaxID: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "borgbackup_repo_segment_max_id",
+				Help: "Highest segment id present in the repository's data/ directory",
+			},
+			[]string{"repo"},
+		),
+		repoConfigMtimeSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "borgbackup_repo_config_mtime_seconds",
+				Help: "Unix timestamp of the last modification of the repository's config file",
+			},
+			[]string{"repo"},
+		),
+		repoSpecialFilePresent: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "borgbackup_repo_special_file_present",
+				Help: "Whether a notable repository file (nonce, integrity.1) is present",
+			},
+			[]string{"repo", "file"},
+		),
+		scrapeErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "borgbackup_scrape_errors_total",
+				Help: "Total number of errors encountered while scraping a repository",
+			},
+			[]string{"repo"},
+		),
+		scrapeDurationSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "borgbackup_scrape_duration_seconds",
+				Help: "Time spent scraping a repository for all metrics",
+			},
+			[]string{"repo"},
+		),
+	}
+}
+
+// DeleteRepo removes every series for repo from m, so a repo that's been
+// removed from config.json (and whose worker has been stopped) disappears
+// from /metrics instead of being published at its last-scraped value forever.
+func (m *repoMetrics) DeleteRepo(repo string) {
+	m.lastTransactionTimestamp.DeleteLabelValues(repo)
+	m.lastTransactionNumber.DeleteLabelValues(repo)
+	m.repoSizeBytes.DeleteLabelValues(repo)
+	m.repoSegmentCount.DeleteLabelValues(repo)
+	m.repoSegmentMaxID.DeleteLabelValues(repo)
+	m.repoConfigMtimeSeconds.DeleteLabelValues(repo)
+	m.repoSpecialFilePresent.DeletePartialMatch(prometheus.Labels{"repo": repo})
+	m.scrapeErrorsTotal.DeleteLabelValues(repo)
+	m.scrapeDurationSeconds.DeleteLabelValues(repo)
+}
+
+func (m *repoMetrics) MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(
+		m.lastTransactionTimestamp,
+		m.lastTransactionNumber,
+		m.repoSizeBytes,
+		m.repoSegmentCount,
+		m.repoSegmentMaxID,
+		m.repoConfigMtimeSeconds,
+		m.repoSpecialFilePresent,
+		m.scrapeErrorsTotal,
+		m.scrapeDurationSeconds,
+	)
+}
+
+var globalMetrics = newRepoMetrics()
+
+func init() {
+	globalMetrics.MustRegister(prometheus.DefaultRegisterer)
+}
+
+// repoSpecialFiles lists the notable repo-root files whose presence is
+// surfaced via borgbackup_repo_special_file_present.
+var repoSpecialFiles = []string{"nonce", "integrity.1"}
+
+// updateRepoMetrics runs every collector for a repo, isolating failures so
+// that one broken collector (say, a missing config file) doesn't prevent
+// the others from reporting. It returns the first error encountered, which
+// is enough for the /probe handler to mark the scrape as failed; each
+// individual failure is also counted and logged.
+func updateRepoMetrics(ctx context.Context, source RepoSource, metrics *repoMetrics) error {
+	repo := source.Name()
+	logger := loggerFromContext(ctx)
+
+	start := time.Now()
+	defer func() {
+		metrics.scrapeDurationSeconds.WithLabelValues(repo).Observe(time.Since(start).Seconds())
+	}()
+
+	collectors := []struct {
+		name string
+		fn   func(ctx context.Context, source RepoSource, metrics *repoMetrics, repo string) error
+	}{
+		{"transactions", collectTransactionMetrics},
+		{"segments", collectSegmentMetrics},
+		{"config", collectConfigMetrics},
+		{"special_files", collectSpecialFileMetrics},
+	}
+
+	var firstErr error
+	for _, c := range collectors {
+		if err := c.fn(ctx, source, metrics, repo); err != nil {
+			metrics.scrapeErrorsTotal.WithLabelValues(repo).Inc()
+			logger.Error("Collector failed", "collector", c.name, "repo", repo, "error", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", c.name, err)
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// collectTransactionMetrics parses the last line of the transactions file
+// to report the repo's most recent transaction number and timestamp.
+func collectTransactionMetrics(ctx context.Context, source RepoSource, metrics *repoMetrics, repo string) error {
+	file, err := source.OpenTransactions(ctx)
+	if err != nil {
+		return fmt.Errorf("open transactions file: %w", err)
+	}
+	defer file.Close()
+
+	var lastLine string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lastLine = scanner.Text()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read transactions file: %w", err)
+	}
+
+	transactionNumber, timestamp, err := parseTransactionLine(lastLine)
+	if err != nil {
+		return fmt.Errorf("parse transactions file: %w", err)
+	}
+
+	loggerFromContext(ctx).Debug("Collected last transaction", "repo", repo, "file", "transactions", "transaction", transactionNumber)
+
+	metrics.lastTransactionTimestamp.WithLabelValues(repo).Set(float64(timestamp))
+	metrics.lastTransactionNumber.WithLabelValues(repo).Set(float64(transactionNumber))
+	return nil
+}
+
+// collectSegmentMetrics reports the repo's total on-disk size, segment
+// count, and highest segment id by walking its data/ directory.
+func collectSegmentMetrics(ctx context.Context, source RepoSource, metrics *repoMetrics, repo string) error {
+	stats, err := source.WalkDataSegments(ctx)
+	if err != nil {
+		return fmt.Errorf("walk data segments: %w", err)
+	}
+
+	metrics.repoSizeBytes.WithLabelValues(repo).Set(float64(stats.TotalSizeBytes))
+	metrics.repoSegmentCount.WithLabelValues(repo).Set(float64(stats.SegmentCount))
+	metrics.repoSegmentMaxID.WithLabelValues(repo).Set(float64(stats.MaxSegmentID))
+	return nil
+}
+
+// collectConfigMetrics reports the repo config file's modification time,
+// which is a cheap signal that the repo's metadata recently changed.
+func collectConfigMetrics(ctx context.Context, source RepoSource, metrics *repoMetrics, repo string) error {
+	stat, err := source.StatFile(ctx, "config")
+	if err != nil {
+		return fmt.Errorf("stat config file: %w", err)
+	}
+
+	metrics.repoConfigMtimeSeconds.WithLabelValues(repo).Set(float64(stat.ModTime.Unix()))
+	return nil
+}
+
+// collectSpecialFileMetrics reports whether notable repo-root files (the
+// encryption nonce, the integrity index) are present.
+func collectSpecialFileMetrics(ctx context.Context, source RepoSource, metrics *repoMetrics, repo string) error {
+	for _, name := range repoSpecialFiles {
+		exists, err := source.FileExists(ctx, name)
+		if err != nil {
+			return fmt.Errorf("check %s presence: %w", name, err)
+		}
+
+		value := 0.0
+		if exists {
+			value = 1.0
+		}
+		metrics.repoSpecialFilePresent.WithLabelValues(repo, name).Set(value)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeRepoFixture(t *testing.T, root string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(root, "transactions"), []byte("transaction 1, UTC time 2024-11-30T11:45:36.870201\n"), 0o644); err != nil {
+		t.Fatalf("writing transactions fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "config"), []byte("[repository]\n"), 0o644); err != nil {
+		t.Fatalf("writing config fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "nonce"), []byte("0\n"), 0o644); err != nil {
+		t.Fatalf("writing nonce fixture: %v", err)
+	}
+
+	dataDir := filepath.Join(root, "data", "0")
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		t.Fatalf("creating data dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "1"), []byte("segment-1"), 0o644); err != nil {
+		t.Fatalf("writing segment fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "2"), []byte("segment-2-longer"), 0o644); err != nil {
+		t.Fatalf("writing segment fixture: %v", err)
+	}
+}
+
+func TestUpdateRepoMetricsCollectsAllMetrics(t *testing.T) {
+	root := t.TempDir()
+	writeRepoFixture(t, root)
+
+	source := newLocalFSSource(root)
+	metrics := newRepoMetrics()
+
+	if err := updateRepoMetrics(context.Background(), source, metrics); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.lastTransactionNumber.WithLabelValues(root)); got != 1 {
+		t.Errorf("Expected last transaction number 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.repoSegmentCount.WithLabelValues(root)); got != 2 {
+		t.Errorf("Expected segment count 2, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.repoSegmentMaxID.WithLabelValues(root)); got != 2 {
+		t.Errorf("Expected max segment id 2, got %v", got)
+	}
+	wantSize := float64(len("segment-1") + len("segment-2-longer"))
+	if got := testutil.ToFloat64(metrics.repoSizeBytes.WithLabelValues(root)); got != wantSize {
+		t.Errorf("Expected repo size %v, got %v", wantSize, got)
+	}
+	if got := testutil.ToFloat64(metrics.repoSpecialFilePresent.WithLabelValues(root, "nonce")); got != 1 {
+		t.Errorf("Expected nonce to be present, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.repoSpecialFilePresent.WithLabelValues(root, "integrity.1")); got != 0 {
+		t.Errorf("Expected integrity.1 to be absent, got %v", got)
+	}
+}
+
+func TestRepoMetricsDeleteRepoRemovesAllSeries(t *testing.T) {
+	root := t.TempDir()
+	writeRepoFixture(t, root)
+
+	source := newLocalFSSource(root)
+	metrics := newRepoMetrics()
+
+	if err := updateRepoMetrics(context.Background(), source, metrics); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	metrics.DeleteRepo(root)
+
+	if got := testutil.CollectAndCount(metrics.lastTransactionTimestamp); got != 0 {
+		t.Errorf("Expected lastTransactionTimestamp to have no series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(metrics.repoSizeBytes); got != 0 {
+		t.Errorf("Expected repoSizeBytes to have no series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(metrics.repoSpecialFilePresent); got != 0 {
+		t.Errorf("Expected repoSpecialFilePresent to have no series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(metrics.scrapeErrorsTotal); got != 0 {
+		t.Errorf("Expected scrapeErrorsTotal to have no series, got %d", got)
+	}
+}
+
+func TestUpdateRepoMetricsIsolatesCollectorFailures(t *testing.T) {
+	root := t.TempDir()
+	// No transactions file and no config file: the transactions and config
+	// collectors should fail, but segment and special-file collection
+	// should still run and report zero values.
+	dataDir := filepath.Join(root, "data", "0")
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		t.Fatalf("creating data dir: %v", err)
+	}
+
+	source := newLocalFSSource(root)
+	metrics := newRepoMetrics()
+
+	if err := updateRepoMetrics(context.Background(), source, metrics); err == nil {
+		t.Fatalf("Expected an error because transactions/config are missing")
+	}
+
+	if got := testutil.ToFloat64(metrics.repoSegmentCount.WithLabelValues(root)); got != 0 {
+		t.Errorf("Expected segment count 0, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.repoSpecialFilePresent.WithLabelValues(root, "nonce")); got != 0 {
+		t.Errorf("Expected nonce to be absent, got %v", got)
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// RepoSource abstracts away where a Borg repository's files live, so the
+// collectors in metrics.go don't need to know whether they're reading a
+// local mount, an SFTP server, or an S3 bucket.
+type RepoSource interface {
+	// OpenTransactions opens the repo's transactions file for reading. The
+	// caller is responsible for closing the returned ReadCloser.
+	OpenTransactions(ctx context.Context) (io.ReadCloser, error)
+	// StatFile returns the size and modification time of a file at the
+	// repo's root (e.g. "config").
+	StatFile(ctx context.Context, name string) (FileStat, error)
+	// FileExists reports whether a file at the repo's root exists (e.g.
+	// "nonce", "integrity.1").
+	FileExists(ctx context.Context, name string) (bool, error)
+	// WalkDataSegments summarizes the repo's data/ segment files.
+	WalkDataSegments(ctx context.Context) (SegmentStats, error)
+	// Name is the stable label value used to identify this repo in
+	// exported metrics.
+	Name() string
+}
+
+// FileStat is the subset of file metadata the metric collectors need,
+// independent of which backend served it.
+type FileStat struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// SegmentStats summarizes a repo's data/ segment files.
+type SegmentStats struct {
+	TotalSizeBytes int64
+	SegmentCount   int
+	MaxSegmentID   int
+}
+
+// segmentIDFromName parses a Borg segment file's base name (a plain
+// integer, e.g. "data/0/5" -> "5") into its segment id. Names that aren't
+// purely numeric (directories, stray files) are reported as not a segment.
+func segmentIDFromName(name string) (int, bool) {
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// RepoSpec describes one configured repo. It unmarshals from either a bare
+// string (the historical local-path form) or a JSON object carrying a URL
+// plus any credentials the backend needs.
+type RepoSpec struct {
+	URL               string `json:"url"`
+	SSHKeyFile        string `json:"ssh_key_file,omitempty"`
+	SSHPassword       string `json:"ssh_password,omitempty"`
+	SSHKnownHostsFile string `json:"ssh_known_hosts_file,omitempty"`
+	S3Region          string `json:"s3_region,omitempty"`
+	S3Endpoint        string `json:"s3_endpoint,omitempty"`
+}
+
+func (s *RepoSpec) UnmarshalJSON(data []byte) error {
+	var asString string
+	if err := json.Unmarshal(data, &asString); err == nil {
+		s.URL = asString
+		return nil
+	}
+
+	type repoSpecAlias RepoSpec
+	var asObject repoSpecAlias
+	if err := json.Unmarshal(data, &asObject); err != nil {
+		return fmt.Errorf("repo entry must be a string or an object with a url field: %w", err)
+	}
+	*s = RepoSpec(asObject)
+	return nil
+}
+
+// newRepoSource selects a RepoSource implementation by URL scheme: a bare
+// path or a file:// URL is served locally, ssh:// is read over SFTP, and
+// s3:// is read from an S3-compatible bucket. reposRoot, if non-empty,
+// constrains local repos to that directory; see resolveLocalRepoPath.
+func newRepoSource(spec RepoSpec, reposRoot string) (RepoSource, error) {
+	u, err := url.Parse(spec.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo url %q: %w", spec.URL, err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		rawPath := u.Path
+		if rawPath == "" {
+			rawPath = spec.URL
+		}
+		cleanPath, err := resolveLocalRepoPath(rawPath, reposRoot)
+		if err != nil {
+			return nil, err
+		}
+		return newLocalFSSource(cleanPath), nil
+	case "ssh":
+		return newSSHSource(u, spec)
+	case "s3":
+		return newS3Source(u, spec)
+	default:
+		return nil, fmt.Errorf("unsupported repo source scheme %q in %q", u.Scheme, spec.URL)
+	}
+}
+
+// newRepoSources builds a RepoSource for every configured repo, in order,
+// failing fast if any entry is misconfigured.
+func newRepoSources(specs []RepoSpec, reposRoot string) ([]RepoSource, error) {
+	sources := make([]RepoSource, 0, len(specs))
+	for _, spec := range specs {
+		source, err := newRepoSource(spec, reposRoot)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// resolveLocalRepoPath turns a config-supplied repo path into a cleaned,
+// absolute path, and optionally enforces that it lives under reposRoot. This
+// exists because the path ultimately flows into localFSSource's file opens:
+// without it, a config entry like "../../etc" (malicious or just a typo)
+// would let the exporter read arbitrary files on the host.
+func resolveLocalRepoPath(rawPath, reposRoot string) (string, error) {
+	absPath, err := filepath.Abs(rawPath)
+	if err != nil {
+		return "", fmt.Errorf("resolving repo path %q: %w", rawPath, err)
+	}
+	cleanPath := filepath.Clean(absPath)
+
+	if reposRoot == "" {
+		return cleanPath, nil
+	}
+
+	absRoot, err := filepath.Abs(reposRoot)
+	if err != nil {
+		return "", fmt.Errorf("resolving repos_root %q: %w", reposRoot, err)
+	}
+	absRoot = filepath.Clean(absRoot)
+
+	rel, err := filepath.Rel(absRoot, cleanPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("repo path %q escapes repos_root %q", rawPath, reposRoot)
+	}
+
+	return cleanPath, nil
+}
+
+// localFSSource reads a repo mounted on the local filesystem. This is the
+// exporter's original and still most common deployment shape. Every file
+// access is scoped through an os.Root rooted at path, so a symlink inside
+// the repo (planted by a compromised backup client, say) can't be used to
+// read or walk files outside it.
+type localFSSource struct {
+	path string
+}
+
+func newLocalFSSource(path string) *localFSSource {
+	return &localFSSource{path: path}
+}
+
+func (s *localFSSource) Name() string {
+	return s.path
+}
+
+// openRoot opens an os.Root scoped to the repo directory. Every method
+// below opens its own root rather than caching one on the struct, matching
+// the rest of the codebase's per-scrape, no-shared-state style.
+func (s *localFSSource) openRoot() (*os.Root, error) {
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("opening repo root %s: %w", s.path, err)
+	}
+	return root, nil
+}
+
+func (s *localFSSource) OpenTransactions(ctx context.Context) (io.ReadCloser, error) {
+	root, err := s.openRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := root.Open("transactions")
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		root.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		root.Close()
+		return nil, fmt.Errorf("transactions file in %s is not a regular file", s.path)
+	}
+
+	return &rootScopedFile{File: file, root: root}, nil
+}
+
+func (s *localFSSource) StatFile(ctx context.Context, name string) (FileStat, error) {
+	root, err := s.openRoot()
+	if err != nil {
+		return FileStat{}, err
+	}
+	defer root.Close()
+
+	info, err := root.Stat(name)
+	if err != nil {
+		return FileStat{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileStat{}, fmt.Errorf("%s in %s is not a regular file", name, s.path)
+	}
+	return FileStat{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *localFSSource) FileExists(ctx context.Context, name string) (bool, error) {
+	root, err := s.openRoot()
+	if err != nil {
+		return false, err
+	}
+	defer root.Close()
+
+	_, err = root.Stat(name)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *localFSSource) WalkDataSegments(ctx context.Context) (SegmentStats, error) {
+	root, err := s.openRoot()
+	if err != nil {
+		return SegmentStats{}, err
+	}
+	defer root.Close()
+
+	var stats SegmentStats
+	err = fs.WalkDir(root.FS(), "data", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stats.TotalSizeBytes += info.Size()
+		stats.SegmentCount++
+		if id, ok := segmentIDFromName(d.Name()); ok && id > stats.MaxSegmentID {
+			stats.MaxSegmentID = id
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return SegmentStats{}, nil
+		}
+		return SegmentStats{}, err
+	}
+
+	return stats, nil
+}
+
+// rootScopedFile closes the repo's os.Root handle along with the file it
+// opened, so every scoped open has a matching teardown.
+type rootScopedFile struct {
+	*os.File
+	root *os.Root
+}
+
+func (f *rootScopedFile) Close() error {
+	fileErr := f.File.Close()
+	rootErr := f.root.Close()
+	if fileErr != nil {
+		return fileErr
+	}
+	return rootErr
+}
+
+// sshSource reads a repo stored on a remote backup target over SFTP,
+// for exporters that shouldn't need the repo mounted locally.
+type sshSource struct {
+	addr           string
+	path           string
+	user           string
+	keyFile        string
+	password       string
+	knownHostsFile string
+}
+
+func newSSHSource(u *url.URL, spec RepoSpec) (*sshSource, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("ssh repo url %q must include a user, e.g. ssh://user@host/path", u.String())
+	}
+	if spec.SSHKnownHostsFile == "" {
+		return nil, fmt.Errorf("ssh repo url %q must set ssh_known_hosts_file to verify the remote host key", u.String())
+	}
+
+	addr := u.Host
+	if !strings.Contains(addr, ":") {
+		addr += ":22"
+	}
+
+	return &sshSource{
+		addr:           addr,
+		path:           u.Path,
+		user:           u.User.Username(),
+		keyFile:        spec.SSHKeyFile,
+		password:       spec.SSHPassword,
+		knownHostsFile: spec.SSHKnownHostsFile,
+	}, nil
+}
+
+func (s *sshSource) Name() string {
+	return fmt.Sprintf("ssh://%s@%s%s", s.user, s.addr, s.path)
+}
+
+func (s *sshSource) authMethods() ([]ssh.AuthMethod, error) {
+	if s.keyFile != "" {
+		key, err := os.ReadFile(s.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ssh key file %s: %w", s.keyFile, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ssh key file %s: %w", s.keyFile, err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+	if s.password != "" {
+		return []ssh.AuthMethod{ssh.Password(s.password)}, nil
+	}
+	return nil, fmt.Errorf("ssh source for %s has neither ssh_key_file nor ssh_password configured", s.addr)
+}
+
+// connect dials the remote host and opens an SFTP session on top of it.
+// Every RepoSource method dials fresh rather than holding a long-lived
+// connection, mirroring the exporter's existing per-scrape, no-shared-state
+// style.
+func (s *sshSource) connect() (*sftp.Client, *ssh.Client, error) {
+	authMethods, err := s.authMethods()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKeyCallback, err := knownhosts.New(s.knownHostsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading ssh known hosts file %s: %w", s.knownHostsFile, err)
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User:            s.user,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	}
+
+	conn, err := ssh.Dial("tcp", s.addr, clientConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh dial %s: %w", s.addr, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("sftp client for %s: %w", s.addr, err)
+	}
+
+	return client, conn, nil
+}
+
+func (s *sshSource) OpenTransactions(ctx context.Context) (io.ReadCloser, error) {
+	client, conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteFile, err := client.Open(path.Join(s.path, "transactions"))
+	if err != nil {
+		client.Close()
+		conn.Close()
+		return nil, fmt.Errorf("open remote transactions file on %s: %w", s.addr, err)
+	}
+
+	return &sshTransactionsReader{File: remoteFile, client: client, conn: conn}, nil
+}
+
+func (s *sshSource) StatFile(ctx context.Context, name string) (FileStat, error) {
+	client, conn, err := s.connect()
+	if err != nil {
+		return FileStat{}, err
+	}
+	defer client.Close()
+	defer conn.Close()
+
+	info, err := client.Stat(path.Join(s.path, name))
+	if err != nil {
+		return FileStat{}, err
+	}
+	return FileStat{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *sshSource) FileExists(ctx context.Context, name string) (bool, error) {
+	client, conn, err := s.connect()
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+	defer conn.Close()
+
+	_, err = client.Stat(path.Join(s.path, name))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *sshSource) WalkDataSegments(ctx context.Context) (SegmentStats, error) {
+	client, conn, err := s.connect()
+	if err != nil {
+		return SegmentStats{}, err
+	}
+	defer client.Close()
+	defer conn.Close()
+
+	var stats SegmentStats
+	walker := client.Walk(path.Join(s.path, "data"))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return SegmentStats{}, err
+		}
+		info := walker.Stat()
+		if info.IsDir() {
+			continue
+		}
+		stats.TotalSizeBytes += info.Size()
+		stats.SegmentCount++
+		if id, ok := segmentIDFromName(info.Name()); ok && id > stats.MaxSegmentID {
+			stats.MaxSegmentID = id
+		}
+	}
+
+	return stats, nil
+}
+
+// sshTransactionsReader closes the remote file along with the SFTP client
+// and SSH connection that back it, so every open has a matching teardown.
+type sshTransactionsReader struct {
+	*sftp.File
+	client *sftp.Client
+	conn   *ssh.Client
+}
+
+func (r *sshTransactionsReader) Close() error {
+	fileErr := r.File.Close()
+	clientErr := r.client.Close()
+	connErr := r.conn.Close()
+	if fileErr != nil {
+		return fileErr
+	}
+	if clientErr != nil {
+		return clientErr
+	}
+	return connErr
+}
+
+// s3Source reads a repo stored in an S3-compatible bucket.
+type s3Source struct {
+	bucket string
+	prefix string
+	client *s3.Client
+}
+
+func newS3Source(u *url.URL, spec RepoSpec) (*s3Source, error) {
+	ctx := context.Background()
+
+	var opts []func(*config.LoadOptions) error
+	if spec.S3Region != "" {
+		opts = append(opts, config.WithRegion(spec.S3Region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config for %q: %w", u.String(), err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if spec.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(spec.S3Endpoint)
+		}
+	})
+
+	return &s3Source{
+		bucket: u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+		client: client,
+	}, nil
+}
+
+func (s *s3Source) Name() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
+
+func (s *s3Source) OpenTransactions(ctx context.Context) (io.ReadCloser, error) {
+	key := path.Join(s.prefix, "transactions")
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get s3 object s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return out.Body, nil
+}
+
+func (s *s3Source) StatFile(ctx context.Context, name string) (FileStat, error) {
+	key := path.Join(s.prefix, name)
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return FileStat{}, fmt.Errorf("head s3 object s3://%s/%s: %w", s.bucket, key, err)
+	}
+
+	var size int64
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	var modTime time.Time
+	if out.LastModified != nil {
+		modTime = *out.LastModified
+	}
+	return FileStat{Size: size, ModTime: modTime}, nil
+}
+
+func (s *s3Source) FileExists(ctx context.Context, name string) (bool, error) {
+	key := path.Join(s.prefix, name)
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("head s3 object s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return true, nil
+}
+
+func (s *s3Source) WalkDataSegments(ctx context.Context) (SegmentStats, error) {
+	var stats SegmentStats
+	prefix := path.Join(s.prefix, "data") + "/"
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return SegmentStats{}, fmt.Errorf("list s3 objects under s3://%s/%s: %w", s.bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			if obj.Size != nil {
+				stats.TotalSizeBytes += *obj.Size
+			}
+			stats.SegmentCount++
+			if id, ok := segmentIDFromName(path.Base(aws.ToString(obj.Key))); ok && id > stats.MaxSegmentID {
+				stats.MaxSegmentID = id
+			}
+		}
+	}
+
+	return stats, nil
+}
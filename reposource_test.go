@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoSpecUnmarshalJSON(t *testing.T) {
+	var fromString RepoSpec
+	if err := json.Unmarshal([]byte(`"/srv/backups/repo1"`), &fromString); err != nil {
+		t.Fatalf("Expected no error unmarshaling a bare string, got %v", err)
+	}
+	if fromString.URL != "/srv/backups/repo1" {
+		t.Errorf("Expected URL %q, got %q", "/srv/backups/repo1", fromString.URL)
+	}
+
+	var fromObject RepoSpec
+	objectJSON := `{"url": "s3://bucket/prefix", "s3_region": "eu-west-1"}`
+	if err := json.Unmarshal([]byte(objectJSON), &fromObject); err != nil {
+		t.Fatalf("Expected no error unmarshaling an object, got %v", err)
+	}
+	if fromObject.URL != "s3://bucket/prefix" || fromObject.S3Region != "eu-west-1" {
+		t.Errorf("Expected URL %q and S3Region %q, got %q and %q", "s3://bucket/prefix", "eu-west-1", fromObject.URL, fromObject.S3Region)
+	}
+}
+
+func TestNewRepoSourceSelectsBackendByScheme(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    RepoSpec
+		wantErr bool
+	}{
+		{name: "bare path", spec: RepoSpec{URL: "/srv/backups/repo1"}},
+		{name: "file scheme", spec: RepoSpec{URL: "file:///srv/backups/repo1"}},
+		{name: "ssh scheme", spec: RepoSpec{URL: "ssh://backup@host/srv/backups/repo1", SSHKnownHostsFile: "/etc/borg-exporter/known_hosts"}},
+		{name: "ssh scheme without user", spec: RepoSpec{URL: "ssh://host/srv/backups/repo1", SSHKnownHostsFile: "/etc/borg-exporter/known_hosts"}, wantErr: true},
+		{name: "ssh scheme without known hosts file", spec: RepoSpec{URL: "ssh://backup@host/srv/backups/repo1"}, wantErr: true},
+		{name: "s3 scheme", spec: RepoSpec{URL: "s3://bucket/prefix"}},
+		{name: "unsupported scheme", spec: RepoSpec{URL: "ftp://host/path"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source, err := newRepoSource(tc.spec, "")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error for %q, got none", tc.spec.URL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error for %q, got %v", tc.spec.URL, err)
+			}
+			if source.Name() == "" {
+				t.Errorf("Expected a non-empty Name() for %q", tc.spec.URL)
+			}
+		})
+	}
+}
+
+func TestResolveLocalRepoPathCleansAndConstrainsToReposRoot(t *testing.T) {
+	reposRoot := t.TempDir()
+	insideRepo := filepath.Join(reposRoot, "repo1")
+	if err := os.Mkdir(insideRepo, 0o755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	cleaned, err := resolveLocalRepoPath(insideRepo, reposRoot)
+	if err != nil {
+		t.Fatalf("Expected no error for a path under repos_root, got %v", err)
+	}
+	if cleaned != filepath.Clean(insideRepo) {
+		t.Errorf("Expected cleaned path %q, got %q", filepath.Clean(insideRepo), cleaned)
+	}
+
+	escaping := filepath.Join(reposRoot, "..", "etc")
+	if _, err := resolveLocalRepoPath(escaping, reposRoot); err == nil {
+		t.Errorf("Expected an error for a repo path escaping repos_root")
+	}
+
+	if _, err := resolveLocalRepoPath("/srv/backups/repo1", ""); err != nil {
+		t.Errorf("Expected no error for a bare path with no repos_root configured, got %v", err)
+	}
+}
+
+func TestLocalFSSourceRejectsSymlinkEscapeAndNonRegularFiles(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := os.WriteFile(secret, []byte("outside the repo"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	repo := t.TempDir()
+	if err := os.Symlink(secret, filepath.Join(repo, "transactions")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	source := newLocalFSSource(repo)
+	if _, err := source.OpenTransactions(context.Background()); err == nil {
+		t.Errorf("Expected an error opening a transactions symlink that escapes the repo root")
+	}
+
+	if err := os.Remove(filepath.Join(repo, "transactions")); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(repo, "transactions"), 0o755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, err := source.OpenTransactions(context.Background()); err == nil {
+		t.Errorf("Expected an error opening a transactions directory instead of a regular file")
+	}
+}
+
+func TestSSHSourceConnectRejectsUnloadableKnownHostsFile(t *testing.T) {
+	u, err := url.Parse("ssh://backup@host/srv/backups/repo1")
+	if err != nil {
+		t.Fatalf("url.Parse failed: %v", err)
+	}
+
+	source, err := newSSHSource(u, RepoSpec{
+		SSHPassword:       "irrelevant",
+		SSHKnownHostsFile: filepath.Join(t.TempDir(), "does-not-exist"),
+	})
+	if err != nil {
+		t.Fatalf("newSSHSource returned an error: %v", err)
+	}
+
+	if _, _, err := source.connect(); err == nil {
+		t.Errorf("Expected connect to fail when ssh_known_hosts_file can't be loaded")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTLSMinVersionFromString(t *testing.T) {
+	cases := map[string]uint16{
+		"":      tls.VersionTLS12,
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+	for input, want := range cases {
+		got, err := tlsMinVersionFromString(input)
+		if err != nil {
+			t.Errorf("tlsMinVersionFromString(%q) returned an error: %v", input, err)
+		}
+		if got != want {
+			t.Errorf("tlsMinVersionFromString(%q) = %v, want %v", input, got, want)
+		}
+	}
+
+	if _, err := tlsMinVersionFromString("TLS9"); err == nil {
+		t.Errorf("Expected an error for an unknown TLS version")
+	}
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-horse"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("Generating bcrypt hash failed: %v", err)
+	}
+	users := map[string]string{"admin": string(hash)}
+
+	handler := basicAuthMiddleware(users, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	noAuthReq := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	noAuthRec := httptest.NewRecorder()
+	handler.ServeHTTP(noAuthRec, noAuthReq)
+	if noAuthRec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with no credentials, got %d", noAuthRec.Code)
+	}
+
+	wrongPassReq := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	wrongPassReq.SetBasicAuth("admin", "wrong")
+	wrongPassRec := httptest.NewRecorder()
+	handler.ServeHTTP(wrongPassRec, wrongPassReq)
+	if wrongPassRec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with wrong password, got %d", wrongPassRec.Code)
+	}
+
+	okReq := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	okReq.SetBasicAuth("admin", "correct-horse")
+	okRec := httptest.NewRecorder()
+	handler.ServeHTTP(okRec, okReq)
+	if okRec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with correct credentials, got %d", okRec.Code)
+	}
+}
+
+func TestBasicAuthMiddlewareNoopWhenNoUsersConfigured(t *testing.T) {
+	handler := basicAuthMiddleware(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 when no basic auth users are configured, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// repoWorkerHandle tracks one repo's background scrape goroutine so it can
+// be cancelled or re-tuned independently of the others.
+type repoWorkerHandle struct {
+	cancel       context.CancelFunc
+	intervalChan chan time.Duration
+}
+
+// workerSupervisor runs one scrape goroutine per repo and reconciles them
+// against a freshly loaded config on SIGHUP: repos that disappeared are
+// cancelled, repos that are new are started, and repos that stuck around
+// keep their goroutine (and their metric state) and just pick up the new
+// scrape interval.
+type workerSupervisor struct {
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	workers map[string]*repoWorkerHandle
+	wg      sync.WaitGroup
+}
+
+func newWorkerSupervisor(logger *slog.Logger) *workerSupervisor {
+	return &workerSupervisor{
+		logger:  logger,
+		workers: make(map[string]*repoWorkerHandle),
+	}
+}
+
+// reconcile starts, stops, and re-tunes per-repo workers so that the
+// running set matches sources at the given interval. Each worker context
+// is derived from parentCtx, so cancelling parentCtx (process shutdown)
+// still stops every worker even though reconcile can also cancel them
+// individually (config reload).
+func (s *workerSupervisor) reconcile(parentCtx context.Context, sources []RepoSource, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[string]RepoSource, len(sources))
+	for _, source := range sources {
+		wanted[source.Name()] = source
+	}
+
+	for name, handle := range s.workers {
+		if _, ok := wanted[name]; !ok {
+			delete(s.workers, name)
+			handle.cancel()
+			globalMetrics.DeleteRepo(name)
+			s.logger.Info("Stopped repo worker", "repo", name)
+		}
+	}
+
+	for name, source := range wanted {
+		if handle, ok := s.workers[name]; ok {
+			select {
+			case handle.intervalChan <- interval:
+			default:
+			}
+			continue
+		}
+
+		workerCtx, cancel := context.WithCancel(parentCtx)
+		intervalChan := make(chan time.Duration, 1)
+		s.workers[name] = &repoWorkerHandle{cancel: cancel, intervalChan: intervalChan}
+
+		s.wg.Add(1)
+		go func(source RepoSource) {
+			defer s.wg.Done()
+			runRepoWorker(workerCtx, source, interval, intervalChan)
+		}(source)
+
+		s.logger.Info("Started repo worker", "repo", name)
+	}
+}
+
+// wait blocks until every worker has exited or timeout elapses, reporting
+// which happened first.
+func (s *workerSupervisor) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// runRepoWorker scrapes a single repo on a ticker until its context is
+// cancelled, updating the ticker's period whenever a new interval arrives
+// on intervalChan (delivered by a config reload).
+func runRepoWorker(ctx context.Context, source RepoSource, interval time.Duration, intervalChan chan time.Duration) {
+	logger := loggerFromContext(ctx)
+	repo := source.Name()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := updateRepoMetrics(ctx, source, globalMetrics); err != nil {
+		logger.Error("Failed to update metrics", "repo", repo, "error", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping repo worker", "repo", repo)
+			return
+		case newInterval := <-intervalChan:
+			ticker.Reset(newInterval)
+		case <-ticker.C:
+			if err := updateRepoMetrics(ctx, source, globalMetrics); err != nil {
+				logger.Error("Failed to update metrics", "repo", repo, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeSource is a minimal RepoSource that never errors, for exercising the
+// worker supervisor without touching the filesystem.
+type fakeSource struct {
+	name string
+}
+
+func (s *fakeSource) Name() string { return s.name }
+func (s *fakeSource) OpenTransactions(ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("transaction 1, UTC time 2024-11-30T11:45:36.870201\n")), nil
+}
+func (s *fakeSource) StatFile(ctx context.Context, name string) (FileStat, error) {
+	return FileStat{}, nil
+}
+func (s *fakeSource) FileExists(ctx context.Context, name string) (bool, error) {
+	return false, nil
+}
+func (s *fakeSource) WalkDataSegments(ctx context.Context) (SegmentStats, error) {
+	return SegmentStats{}, nil
+}
+
+// waitForCondition polls cond until it's true or timeout elapses, failing
+// the test with msg if it never becomes true. Worker goroutines run their
+// first scrape asynchronously, so tests that observe its effect on shared
+// state (globalMetrics) can't assert on it immediately after reconcile.
+func waitForCondition(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s within %s", msg, timeout)
+}
+
+func TestWorkerSupervisorReconcileStartsAndStopsWorkers(t *testing.T) {
+	logger, err := newLogger("logfmt", "error")
+	if err != nil {
+		t.Fatalf("newLogger returned an error: %v", err)
+	}
+
+	supervisor := newWorkerSupervisor(logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := &fakeSource{name: "repo-a"}
+	b := &fakeSource{name: "repo-b"}
+
+	supervisor.reconcile(ctx, []RepoSource{a, b}, time.Hour)
+	if got := len(supervisor.workers); got != 2 {
+		t.Fatalf("Expected 2 workers after initial reconcile, got %d", got)
+	}
+
+	// Dropping repo-b from the wanted set should stop only its worker.
+	supervisor.reconcile(ctx, []RepoSource{a}, time.Hour)
+	if got := len(supervisor.workers); got != 1 {
+		t.Fatalf("Expected 1 worker after removing repo-b, got %d", got)
+	}
+	if _, ok := supervisor.workers["repo-a"]; !ok {
+		t.Errorf("Expected repo-a's worker to still be running")
+	}
+
+	cancel()
+	if !supervisor.wait(2 * time.Second) {
+		t.Errorf("Expected all workers to stop promptly after cancellation")
+	}
+}
+
+func TestWorkerSupervisorReconcileDeletesMetricsForRemovedRepos(t *testing.T) {
+	logger, err := newLogger("logfmt", "error")
+	if err != nil {
+		t.Fatalf("newLogger returned an error: %v", err)
+	}
+
+	supervisor := newWorkerSupervisor(logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	removed := &fakeSource{name: "repo-removed-in-reload"}
+	supervisor.reconcile(ctx, []RepoSource{removed}, time.Hour)
+
+	waitForCondition(t, 2*time.Second, func() bool {
+		return testutil.ToFloat64(globalMetrics.lastTransactionNumber.WithLabelValues(removed.name)) == 1
+	}, "Expected the initial scrape to populate metrics for "+removed.name)
+
+	supervisor.reconcile(ctx, nil, time.Hour)
+
+	if got := testutil.ToFloat64(globalMetrics.lastTransactionNumber.WithLabelValues(removed.name)); got != 0 {
+		t.Errorf("Expected metrics for %s to be deleted once its worker stopped, got %v", removed.name, got)
+	}
+}